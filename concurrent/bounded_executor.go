@@ -0,0 +1,184 @@
+package concurrent
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/v2pro/plz/countlog"
+)
+
+// BoundedExecutor runs submitted handlers on a fixed pool of worker
+// goroutines backed by a buffered job queue, instead of spawning a new
+// goroutine per handler like UnboundedExecutor does. Use it for
+// per-request fan-out where an unbounded number of goroutines would be
+// dangerous.
+type BoundedExecutor struct {
+	ctx                   context.Context
+	cancel                context.CancelFunc
+	activeGoroutinesMutex *sync.Mutex
+	activeGoroutines      map[string]int
+	jobs                  chan boundedJob
+	queued                int32
+	running               int32
+	rejected              int32
+}
+
+type boundedJob struct {
+	startFrom string
+	handler   func(ctx context.Context)
+}
+
+// BoundedExecutorMetrics is a point-in-time snapshot of a BoundedExecutor's
+// job queue.
+type BoundedExecutorMetrics struct {
+	Queued   int
+	Running  int
+	Rejected int
+}
+
+// NewBoundedExecutor creates a BoundedExecutor with maxConcurrency worker
+// goroutines pulling from a job queue with capacity queueSize.
+func NewBoundedExecutor(maxConcurrency int, queueSize int) *BoundedExecutor {
+	ctx, cancel := context.WithCancel(context.TODO())
+	executor := &BoundedExecutor{
+		ctx:                   ctx,
+		cancel:                cancel,
+		activeGoroutinesMutex: &sync.Mutex{},
+		activeGoroutines:      map[string]int{},
+		jobs:                  make(chan boundedJob, queueSize),
+	}
+	for i := 0; i < maxConcurrency; i++ {
+		executor.startWorker()
+	}
+	return executor
+}
+
+func (executor *BoundedExecutor) startWorker() {
+	go func() {
+		for {
+			select {
+			case job := <-executor.jobs:
+				executor.handleJob(job)
+			case <-executor.ctx.Done():
+				executor.drainJobs()
+				return
+			}
+		}
+	}()
+}
+
+func (executor *BoundedExecutor) handleJob(job boundedJob) {
+	atomic.AddInt32(&executor.queued, -1)
+	atomic.AddInt32(&executor.running, 1)
+	executor.runJob(job)
+	atomic.AddInt32(&executor.running, -1)
+}
+
+// drainJobs runs every job still sitting in the buffered queue at the
+// moment ctx is canceled, so Stop/StopAndWait never strand already-accepted
+// jobs the way a bare "select on ctx.Done()" would: without this, a job
+// that lost the race between being queued and ctx firing would sit in the
+// channel forever, with Metrics().Queued stuck reporting it as pending.
+func (executor *BoundedExecutor) drainJobs() {
+	for {
+		select {
+		case job := <-executor.jobs:
+			executor.handleJob(job)
+		default:
+			return
+		}
+	}
+}
+
+func (executor *BoundedExecutor) runJob(job boundedJob) {
+	executor.activeGoroutinesMutex.Lock()
+	executor.activeGoroutines[job.startFrom] += 1
+	executor.activeGoroutinesMutex.Unlock()
+	defer func() {
+		recovered := recover()
+		if recovered != nil && recovered != StopSignal {
+			countlog.Fatal("event!bounded_executor.panic",
+				"err", recovered,
+				"stacktrace", countlog.ProvideStacktrace)
+		}
+		executor.activeGoroutinesMutex.Lock()
+		defer executor.activeGoroutinesMutex.Unlock()
+		executor.activeGoroutines[job.startFrom] -= 1
+	}()
+	job.handler(executor.ctx)
+}
+
+// Submit enqueues handler to run on the worker pool. It never blocks
+// forever: it returns ctx.Err() if ctx is already done, an error if the
+// executor has been stopped, or an error if the job queue is full.
+func (executor *BoundedExecutor) Submit(ctx context.Context, handler func(ctx context.Context)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if executor.ctx.Err() != nil {
+		return fmt.Errorf("concurrent: bounded executor is stopped")
+	}
+	_, file, line, _ := runtime.Caller(1)
+	startFrom := fmt.Sprintf("%s:%d", file, line)
+	select {
+	case executor.jobs <- boundedJob{startFrom: startFrom, handler: handler}:
+		atomic.AddInt32(&executor.queued, 1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		atomic.AddInt32(&executor.rejected, 1)
+		return fmt.Errorf("concurrent: bounded executor queue is full")
+	}
+}
+
+// Metrics returns a snapshot of the executor's queued, running and
+// rejected job counts.
+func (executor *BoundedExecutor) Metrics() BoundedExecutorMetrics {
+	return BoundedExecutorMetrics{
+		Queued:   int(atomic.LoadInt32(&executor.queued)),
+		Running:  int(atomic.LoadInt32(&executor.running)),
+		Rejected: int(atomic.LoadInt32(&executor.rejected)),
+	}
+}
+
+func (executor *BoundedExecutor) Stop() {
+	executor.cancel()
+}
+
+func (executor *BoundedExecutor) StopAndWaitForever() {
+	executor.StopAndWait(context.Background())
+}
+
+func (executor *BoundedExecutor) StopAndWait(ctx context.Context) {
+	executor.cancel()
+	for {
+		fiveSeconds := time.NewTimer(time.Millisecond * 100)
+		select {
+		case <-fiveSeconds.C:
+		case <-ctx.Done():
+			return
+		}
+		if executor.checkGoroutines() {
+			return
+		}
+	}
+}
+
+func (executor *BoundedExecutor) checkGoroutines() bool {
+	executor.activeGoroutinesMutex.Lock()
+	defer executor.activeGoroutinesMutex.Unlock()
+	for startFrom, count := range executor.activeGoroutines {
+		if count > 0 {
+			countlog.Info("event!bounded_executor.still waiting goroutines to quit",
+				"startFrom", startFrom,
+				"count", count)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package concurrent
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/json-iterator/go/require"
+)
+
+// Test_BoundedExecutor_StopAndWaitForever guards against worker goroutines
+// blocking forever on the job channel after Stop: StopAndWaitForever must
+// return once every worker has observed ctx.Done(), even with no more jobs
+// coming in.
+func Test_BoundedExecutor_StopAndWaitForever(t *testing.T) {
+	should := require.New(t)
+	executor := NewBoundedExecutor(2, 4)
+	done := make(chan struct{})
+	should.Nil(executor.Submit(context.Background(), func(ctx context.Context) {
+		close(done)
+	}))
+	<-done
+
+	stopped := make(chan struct{})
+	go func() {
+		executor.StopAndWaitForever()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("StopAndWaitForever did not return; worker goroutines likely leaked")
+	}
+}
+
+// Test_BoundedExecutor_Stop_drains_already_accepted_jobs reproduces a size-1
+// pool with a blocking job plus several queued behind it: Stop must not
+// strand the queued jobs in the channel just because the worker observes
+// ctx.Done() before it gets back around to reading them.
+func Test_BoundedExecutor_Stop_drains_already_accepted_jobs(t *testing.T) {
+	should := require.New(t)
+	executor := NewBoundedExecutor(1, 10)
+	unblock := make(chan struct{})
+	started := make(chan struct{})
+	should.Nil(executor.Submit(context.Background(), func(ctx context.Context) {
+		close(started)
+		<-unblock
+	}))
+	// wait until the worker holds the blocking job, so the queue has room
+	// for all queuedJobs behind it
+	<-started
+
+	const queuedJobs = 10
+	var ran int32
+	for i := 0; i < queuedJobs; i++ {
+		should.Nil(executor.Submit(context.Background(), func(ctx context.Context) {
+			atomic.AddInt32(&ran, 1)
+		}))
+	}
+
+	executor.Stop()
+	close(unblock)
+	executor.StopAndWaitForever()
+
+	should.Equal(int32(queuedJobs), atomic.LoadInt32(&ran))
+	should.Equal(0, executor.Metrics().Queued)
+}
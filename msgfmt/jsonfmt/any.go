@@ -0,0 +1,144 @@
+package jsonfmt
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Any is a lazy handle over a raw fragment of JSON. Parsing is deferred
+// until a caller asks for a value, so subtrees can be navigated,
+// inspected, or re-emitted without ever being fully decoded.
+type Any struct {
+	raw []byte
+	err error
+}
+
+// NewAny wraps a raw JSON fragment as an Any. The fragment is not
+// validated: MustBeValid only reports errors from Get, and the To* methods
+// return a zero value for a fragment they cannot parse.
+func NewAny(raw []byte) Any {
+	return Any{raw: raw}
+}
+
+// Get walks into the fragment following path, where each segment is
+// either a string (object field) or an int (array index), and returns a
+// lazy handle over the selected subtree.
+func (any Any) Get(path ...interface{}) Any {
+	current := any
+	for _, segment := range path {
+		if current.err != nil {
+			return current
+		}
+		switch key := segment.(type) {
+		case string:
+			current = current.getField(key)
+		case int:
+			current = current.getElem(key)
+		default:
+			return Any{err: fmt.Errorf("jsonfmt: unsupported path segment %v", segment)}
+		}
+	}
+	return current
+}
+
+func (any Any) getField(key string) Any {
+	data := skipWhitespace(any.raw)
+	if len(data) == 0 || data[0] != '{' {
+		return Any{err: fmt.Errorf("jsonfmt: %q is not an object", any.raw)}
+	}
+	data = skipWhitespace(data[1:])
+	for len(data) > 0 && data[0] != '}' {
+		fieldKey, remaining, err := readRawString(data)
+		if err != nil {
+			return Any{err: err}
+		}
+		data = skipWhitespace(remaining)
+		if len(data) == 0 || data[0] != ':' {
+			return Any{err: fmt.Errorf("jsonfmt: expected :, got %q", data)}
+		}
+		data = skipWhitespace(data[1:])
+		raw, remaining2, err := readRawValue(data)
+		if err != nil {
+			return Any{err: err}
+		}
+		if fieldKey == key {
+			return Any{raw: raw}
+		}
+		data = skipWhitespace(remaining2)
+		if len(data) > 0 && data[0] == ',' {
+			data = skipWhitespace(data[1:])
+		}
+	}
+	return Any{err: fmt.Errorf("jsonfmt: field %q not found", key)}
+}
+
+func (any Any) getElem(index int) Any {
+	data := skipWhitespace(any.raw)
+	if len(data) == 0 || data[0] != '[' {
+		return Any{err: fmt.Errorf("jsonfmt: %q is not an array", any.raw)}
+	}
+	data = skipWhitespace(data[1:])
+	i := 0
+	for len(data) > 0 && data[0] != ']' {
+		raw, remaining, err := readRawValue(data)
+		if err != nil {
+			return Any{err: err}
+		}
+		if i == index {
+			return Any{raw: raw}
+		}
+		i++
+		data = skipWhitespace(remaining)
+		if len(data) > 0 && data[0] == ',' {
+			data = skipWhitespace(data[1:])
+		}
+	}
+	return Any{err: fmt.Errorf("jsonfmt: index %d out of range", index)}
+}
+
+// MustBeValid panics if navigating to this Any failed, otherwise returns
+// itself so calls can be chained.
+func (any Any) MustBeValid() Any {
+	if any.err != nil {
+		panic(any.err)
+	}
+	return any
+}
+
+// ToInt parses the fragment as an integer, returning 0 if it is invalid.
+func (any Any) ToInt() int {
+	if any.err != nil {
+		return 0
+	}
+	val, err := strconv.ParseInt(string(skipWhitespace(any.raw)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return int(val)
+}
+
+// ToString returns the fragment's string value, unquoting it if it is a
+// JSON string, or its raw text otherwise.
+func (any Any) ToString() string {
+	if any.err != nil {
+		return ""
+	}
+	data := skipWhitespace(any.raw)
+	if len(data) > 0 && data[0] == '"' {
+		str, _, err := readRawString(data)
+		if err != nil {
+			return ""
+		}
+		return str
+	}
+	return string(data)
+}
+
+// WriteTo re-emits the raw fragment into stream without decoding it.
+func (any Any) WriteTo(stream *Stream) {
+	if any.err != nil {
+		stream.Error = any.err
+		return
+	}
+	stream.WriteRaw(string(skipWhitespace(any.raw)))
+}
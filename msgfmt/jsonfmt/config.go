@@ -0,0 +1,342 @@
+package jsonfmt
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unsafe"
+
+	"github.com/v2pro/plz/msgfmt/jsonfmt/internal/typesys"
+)
+
+// Naming controls how a struct field name is turned into a JSON key when
+// the field has no explicit name in its tag.
+type Naming int
+
+const (
+	// AsIs uses the Go field name verbatim.
+	AsIs Naming = iota
+	// LowerCamel lower-cases the first letter of the Go field name.
+	LowerCamel
+	// SnakeCase converts CamelCase field names to snake_case.
+	SnakeCase
+)
+
+// Config customizes how EncoderOf builds encoders, so callers can switch
+// naming conventions or tag keys without forking the package.
+type Config struct {
+	// TagKey is the struct tag read for field names and options.
+	// Defaults to "json".
+	TagKey string
+	// Naming is applied to fields that have no explicit tag name.
+	Naming Naming
+	// SortMapKeys sorts map keys by their string representation when
+	// encoding, for deterministic output.
+	SortMapKeys bool
+}
+
+var defaultConfig = Config{}
+
+func (cfg Config) tagKey() string {
+	if cfg.TagKey == "" {
+		return "json"
+	}
+	return cfg.TagKey
+}
+
+var configEncoderCache = &sync.Map{}
+
+type configCacheKey struct {
+	cfg     Config
+	valType reflect.Type
+}
+
+// EncoderOf is like the package-level EncoderOf, but applies cfg's tag
+// key, naming strategy and map key sorting to the structs, pointers,
+// slices, arrays and sorted maps it reaches. The values of maps encoded
+// without SortMapKeys, and values held in interfaces, are encoded with the
+// package-level encoders, which do not use cfg.
+func (cfg Config) EncoderOf(valType reflect.Type) Encoder {
+	key := configCacheKey{cfg: cfg, valType: valType}
+	if cached, found := configEncoderCache.Load(key); found {
+		return cached.(Encoder)
+	}
+	encoder := cfg.encoderOf("", valType)
+	if typesys.Of(valType).IsDirect() {
+		encoder = &onePtrInterfaceEncoder{encoder}
+	}
+	configEncoderCache.Store(key, encoder)
+	return encoder
+}
+
+func (cfg Config) encoderOf(prefix string, valType reflect.Type) Encoder {
+	if encoder := specialCaseEncoder(valType); encoder != nil {
+		return encoder
+	}
+	switch valType.Kind() {
+	case reflect.Struct:
+		return cfg.encoderOfStruct(prefix, valType)
+	case reflect.Map:
+		if cfg.SortMapKeys {
+			return &sortedMapEncoder{
+				mapType:     valType,
+				keyEncoder:  encoderOfMapKey(prefix, valType.Key()),
+				elemEncoder: cfg.encoderOf(prefix+" [mapElem]", valType.Elem()),
+			}
+		}
+		return encoderOfMap(prefix, valType)
+	case reflect.Ptr:
+		return &pointerEncoder{elemEncoder: cfg.encoderOf(prefix+" [ptrElem]", valType.Elem())}
+	case reflect.Slice:
+		elemType := valType.Elem()
+		return &sliceEncoder{
+			elemEncoder: cfg.encoderOf(prefix+" [sliceElem]", elemType),
+			elemSize:    elemType.Size(),
+		}
+	case reflect.Array:
+		elemType := valType.Elem()
+		return &arrayEncoder{
+			elemEncoder: cfg.encoderOf(prefix+" [sliceElem]", elemType),
+			elemSize:    elemType.Size(),
+			length:      valType.Len(),
+		}
+	}
+	return encoderOf(prefix, valType)
+}
+
+type structEncoderField struct {
+	offset    uintptr
+	name      string
+	fieldType reflect.Type
+	encoder   Encoder
+	omitempty bool
+}
+
+type structEncoder struct {
+	fields []structEncoderField
+}
+
+func (encoder *structEncoder) Encode(space []byte, ptr unsafe.Pointer) []byte {
+	space = append(space, '{')
+	wroteField := false
+	for _, field := range encoder.fields {
+		fieldPtr := unsafe.Pointer(uintptr(ptr) + field.offset)
+		if field.omitempty && reflect.NewAt(field.fieldType, fieldPtr).Elem().IsZero() {
+			continue
+		}
+		if wroteField {
+			space = append(space, ',')
+		}
+		space = append(space, '"')
+		space = append(space, field.name...)
+		space = append(space, `":`...)
+		space = field.encoder.Encode(space, fieldPtr)
+		wroteField = true
+	}
+	space = append(space, '}')
+	return space
+}
+
+// EncodeStream implements StreamEncoder, flushing after each field instead
+// of growing a single []byte for the whole struct.
+func (encoder *structEncoder) EncodeStream(stream *Stream, ptr unsafe.Pointer) {
+	stream.WriteRaw("{")
+	wroteField := false
+	for _, field := range encoder.fields {
+		fieldPtr := unsafe.Pointer(uintptr(ptr) + field.offset)
+		if field.omitempty && reflect.NewAt(field.fieldType, fieldPtr).Elem().IsZero() {
+			continue
+		}
+		if wroteField {
+			stream.WriteRaw(",")
+		}
+		stream.WriteString(field.name)
+		stream.WriteRaw(":")
+		stream.WriteVal(field.encoder, fieldPtr)
+		wroteField = true
+		if stream.Error != nil {
+			return
+		}
+		if err := stream.Flush(); err != nil {
+			return
+		}
+	}
+	stream.WriteRaw("}")
+}
+
+// namedField tracks the depth a candidate field was found at, so that a
+// shallower field (one promoted through fewer levels of inlining) wins
+// over a deeper one with the same JSON name, matching encoding/json.
+type namedField struct {
+	structEncoderField
+	depth int
+}
+
+func (cfg Config) encoderOfStruct(prefix string, valType reflect.Type) *structEncoder {
+	byName := map[string]namedField{}
+	var order []string
+	cfg.addStructFields(prefix, valType, 0, 0, byName, &order)
+	fields := make([]structEncoderField, 0, len(order))
+	for _, name := range order {
+		field, found := byName[name]
+		if !found || field.encoder == nil {
+			continue
+		}
+		fields = append(fields, field.structEncoderField)
+	}
+	return &structEncoder{fields: fields}
+}
+
+func (cfg Config) addStructFields(prefix string, valType reflect.Type, depth int, baseOffset uintptr, byName map[string]namedField, order *[]string) {
+	for i := 0; i < valType.NumField(); i++ {
+		field := valType.Field(i)
+		tag := field.Tag.Get(cfg.tagKey())
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			cfg.addStructFields(prefix, field.Type, depth+1, baseOffset+field.Offset, byName, order)
+			continue
+		}
+		name, omitempty, asString := cfg.parseTag(field, tag)
+		if name == "" {
+			continue
+		}
+		existing, found := byName[name]
+		if found && existing.depth <= depth {
+			if existing.depth == depth {
+				// ambiguous at this depth: keep an entry with no encoder so
+				// neither field, nor a deeper one, is encoded
+				byName[name] = namedField{depth: depth}
+			}
+			continue
+		}
+		fieldEncoder := cfg.encoderOf(prefix+" ."+name, field.Type)
+		if asString {
+			switch field.Type.Kind() {
+			case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64:
+				fieldEncoder = &asStringEncoder{elemEncoder: fieldEncoder}
+			}
+		}
+		if !found {
+			*order = append(*order, name)
+		}
+		byName[name] = namedField{
+			structEncoderField: structEncoderField{
+				offset:    baseOffset + field.Offset,
+				name:      name,
+				fieldType: field.Type,
+				encoder:   fieldEncoder,
+				omitempty: omitempty,
+			},
+			depth: depth,
+		}
+	}
+}
+
+// parseTag applies the json:"name,omitempty,string" grammar: an unexported
+// or "-" field is skipped, an empty name falls back to cfg's naming
+// strategy, and the omitempty/string options are reported separately.
+func (cfg Config) parseTag(field reflect.StructField, tag string) (name string, omitempty bool, asString bool) {
+	if !unicode.IsUpper(rune(field.Name[0])) {
+		return "", false, false
+	}
+	if tag == "" {
+		return cfg.convertName(field.Name), false, false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "-" && len(parts) == 1 {
+		return "", false, false
+	}
+	if name == "" {
+		name = cfg.convertName(field.Name)
+	}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "omitempty":
+			omitempty = true
+		case "string":
+			asString = true
+		}
+	}
+	return name, omitempty, asString
+}
+
+func (cfg Config) convertName(name string) string {
+	switch cfg.Naming {
+	case LowerCamel:
+		if name == "" {
+			return name
+		}
+		runes := []rune(name)
+		runes[0] = unicode.ToLower(runes[0])
+		return string(runes)
+	case SnakeCase:
+		var out strings.Builder
+		for i, r := range name {
+			if unicode.IsUpper(r) {
+				if i > 0 {
+					out.WriteByte('_')
+				}
+				out.WriteRune(unicode.ToLower(r))
+			} else {
+				out.WriteRune(r)
+			}
+		}
+		return out.String()
+	default:
+		return name
+	}
+}
+
+// asStringEncoder wraps a numeric or bool encoder so its value is emitted
+// as a quoted JSON string, for the "string" tag option.
+type asStringEncoder struct {
+	elemEncoder Encoder
+}
+
+func (encoder *asStringEncoder) Encode(space []byte, ptr unsafe.Pointer) []byte {
+	space = append(space, '"')
+	space = encoder.elemEncoder.Encode(space, ptr)
+	space = append(space, '"')
+	return space
+}
+
+// sortedMapEncoder encodes a map with its keys sorted by their string
+// representation, for Config.SortMapKeys.
+type sortedMapEncoder struct {
+	mapType     reflect.Type
+	keyEncoder  Encoder
+	elemEncoder Encoder
+}
+
+type sortedMapEntry struct {
+	keyText string
+	valPtr  unsafe.Pointer
+}
+
+func (encoder *sortedMapEncoder) Encode(space []byte, ptr unsafe.Pointer) []byte {
+	var entries []sortedMapEntry
+	typesys.Of(encoder.mapType).UnsafeIterate(ptr, func(keyPtr, valPtr unsafe.Pointer) {
+		entries = append(entries, sortedMapEntry{
+			keyText: string(encoder.keyEncoder.Encode(nil, keyPtr)),
+			valPtr:  valPtr,
+		})
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].keyText < entries[j].keyText
+	})
+	space = append(space, '{')
+	for i, entry := range entries {
+		if i > 0 {
+			space = append(space, ',')
+		}
+		space = append(space, entry.keyText...)
+		space = append(space, ':')
+		space = encoder.elemEncoder.Encode(space, entry.valPtr)
+	}
+	space = append(space, '}')
+	return space
+}
@@ -0,0 +1,898 @@
+package jsonfmt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	"github.com/v2pro/plz/msgfmt/jsonfmt/internal/typesys"
+)
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// Decoder parses the JSON value at the front of data into the value
+// pointed to by ptr, and returns the unconsumed remainder of data.
+type Decoder interface {
+	Decode(data []byte, ptr unsafe.Pointer) ([]byte, error)
+}
+
+var decoderCache = &sync.Map{}
+
+func DecoderOf(valType reflect.Type) Decoder {
+	decoderObj, found := decoderCache.Load(valType)
+	if found {
+		return decoderObj.(Decoder)
+	}
+	decoder := decoderOf("", valType)
+	if typesys.Of(valType).IsDirect() {
+		decoder = &onePtrInterfaceDecoder{elemDecoder: decoder}
+	}
+	decoderCache.Store(valType, decoder)
+	return decoder
+}
+
+// onePtrInterfaceDecoder mirrors onePtrInterfaceEncoder on the decode side:
+// valType is stored directly (as a single pointer word) inside an
+// interface{}, so the ptr a caller passes via PtrOf is the pointer itself,
+// not the address of a slot that could be overwritten with a freshly
+// allocated pointer. Decoding in place into an already non-nil pointer
+// works; decoding into a nil one does not, since there is nowhere to write
+// the new pointer back to. Callers that need the latter should pass
+// unsafe.Pointer(&fooPtr) to the wrapped decoder directly instead of going
+// through PtrOf.
+type onePtrInterfaceDecoder struct {
+	elemDecoder Decoder
+}
+
+func (decoder *onePtrInterfaceDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	if ptr == nil {
+		return nil, fmt.Errorf("jsonfmt: can not decode into a nil direct pointer passed through PtrOf")
+	}
+	local := ptr
+	return decoder.elemDecoder.Decode(data, unsafe.Pointer(&local))
+}
+
+func decoderOf(prefix string, valType reflect.Type) Decoder {
+	if bytesType == valType {
+		return &bytesDecoder{}
+	}
+	if reflect.PtrTo(valType).Implements(jsonUnmarshalerType) {
+		return &jsonUnmarshalerDecoder{valType: valType}
+	}
+	switch valType.Kind() {
+	case reflect.Int8:
+		return &int8Decoder{}
+	case reflect.Uint8:
+		return &uint8Decoder{}
+	case reflect.Int16:
+		return &int16Decoder{}
+	case reflect.Uint16:
+		return &uint16Decoder{}
+	case reflect.Int32:
+		return &int32Decoder{}
+	case reflect.Uint32:
+		return &uint32Decoder{}
+	case reflect.Int64, reflect.Int:
+		return &int64Decoder{}
+	case reflect.Uint64, reflect.Uint:
+		return &uint64Decoder{}
+	case reflect.Float64:
+		return &float64Decoder{}
+	case reflect.Float32:
+		return &float32Decoder{}
+	case reflect.String:
+		return &stringDecoder{}
+	case reflect.Ptr:
+		elemDecoder := decoderOf(prefix+" [ptrElem]", valType.Elem())
+		return &pointerDecoder{elemType: valType.Elem(), elemDecoder: elemDecoder}
+	case reflect.Slice:
+		elemType := valType.Elem()
+		return &sliceDecoder{
+			elemType:    elemType,
+			elemDecoder: decoderOf(prefix+" [sliceElem]", elemType),
+			elemSize:    elemType.Size(),
+		}
+	case reflect.Array:
+		elemType := valType.Elem()
+		return &arrayDecoder{
+			elemDecoder: decoderOf(prefix+" [sliceElem]", elemType),
+			elemSize:    elemType.Size(),
+			length:      valType.Len(),
+		}
+	case reflect.Struct:
+		return decoderOfStruct(prefix, valType)
+	case reflect.Map:
+		return decoderOfMap(prefix, valType)
+	case reflect.Interface:
+		if valType.NumMethod() != 0 {
+			return &nonEmptyInterfaceDecoder{}
+		}
+		return &emptyInterfaceDecoder{}
+	}
+	return &unsupportedDecoder{fmt.Sprintf("can not decode %s %s from json", valType.String(), prefix)}
+}
+
+// skipWhitespace advances past JSON insignificant whitespace.
+func skipWhitespace(data []byte) []byte {
+	i := 0
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+			continue
+		}
+		break
+	}
+	return data[i:]
+}
+
+func readNullOr(data []byte, then func(data []byte) ([]byte, error)) ([]byte, error) {
+	data = skipWhitespace(data)
+	if len(data) >= 4 && string(data[:4]) == "null" {
+		return data[4:], nil
+	}
+	return then(data)
+}
+
+// readRawToken returns the bytes making up the next JSON literal (number,
+// true, false or null) together with the remainder of data.
+func readRawToken(data []byte) ([]byte, []byte, error) {
+	data = skipWhitespace(data)
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("jsonfmt: unexpected end of input")
+	}
+	i := 0
+	for i < len(data) {
+		c := data[i]
+		if c == ',' || c == '}' || c == ']' || c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			break
+		}
+		i++
+	}
+	if i == 0 {
+		return nil, nil, fmt.Errorf("jsonfmt: unexpected character %q", data[0])
+	}
+	return data[:i], data[i:], nil
+}
+
+// readRawString consumes a JSON string literal and returns its decoded
+// content along with the remainder of data.
+func readRawString(data []byte) (string, []byte, error) {
+	data = skipWhitespace(data)
+	if len(data) == 0 || data[0] != '"' {
+		return "", nil, fmt.Errorf("jsonfmt: expected string, got %q", data)
+	}
+	i := 1
+	hasEscape := false
+	for i < len(data) {
+		switch data[i] {
+		case '"':
+			raw := data[1:i]
+			remaining := data[i+1:]
+			if !hasEscape {
+				return string(raw), remaining, nil
+			}
+			var decoded string
+			if err := json.Unmarshal(data[:i+1], &decoded); err != nil {
+				return "", nil, err
+			}
+			return decoded, remaining, nil
+		case '\\':
+			hasEscape = true
+			i += 2
+			continue
+		}
+		i++
+	}
+	return "", nil, fmt.Errorf("jsonfmt: unterminated string")
+}
+
+type unsupportedDecoder struct {
+	msg string
+}
+
+func (decoder *unsupportedDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	return nil, fmt.Errorf(decoder.msg)
+}
+
+type int8Decoder struct{}
+
+func (decoder *int8Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseInt(string(token), 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	*(*int8)(ptr) = int8(val)
+	return remaining, nil
+}
+
+type uint8Decoder struct{}
+
+func (decoder *uint8Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseUint(string(token), 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	*(*uint8)(ptr) = uint8(val)
+	return remaining, nil
+}
+
+type int16Decoder struct{}
+
+func (decoder *int16Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseInt(string(token), 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	*(*int16)(ptr) = int16(val)
+	return remaining, nil
+}
+
+type uint16Decoder struct{}
+
+func (decoder *uint16Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseUint(string(token), 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	*(*uint16)(ptr) = uint16(val)
+	return remaining, nil
+}
+
+type int32Decoder struct{}
+
+func (decoder *int32Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseInt(string(token), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	*(*int32)(ptr) = int32(val)
+	return remaining, nil
+}
+
+type uint32Decoder struct{}
+
+func (decoder *uint32Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseUint(string(token), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	*(*uint32)(ptr) = uint32(val)
+	return remaining, nil
+}
+
+type int64Decoder struct{}
+
+func (decoder *int64Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseInt(string(token), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	*(*int64)(ptr) = val
+	return remaining, nil
+}
+
+type uint64Decoder struct{}
+
+func (decoder *uint64Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseUint(string(token), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	*(*uint64)(ptr) = val
+	return remaining, nil
+}
+
+type float64Decoder struct{}
+
+func (decoder *float64Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseFloat(string(token), 64)
+	if err != nil {
+		return nil, err
+	}
+	*(*float64)(ptr) = val
+	return remaining, nil
+}
+
+type float32Decoder struct{}
+
+func (decoder *float32Decoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseFloat(string(token), 32)
+	if err != nil {
+		return nil, err
+	}
+	*(*float32)(ptr) = float32(val)
+	return remaining, nil
+}
+
+type boolDecoder struct{}
+
+func (decoder *boolDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	token, remaining, err := readRawToken(data)
+	if err != nil {
+		return nil, err
+	}
+	switch string(token) {
+	case "true":
+		*(*bool)(ptr) = true
+	case "false":
+		*(*bool)(ptr) = false
+	default:
+		return nil, fmt.Errorf("jsonfmt: invalid bool literal %q", token)
+	}
+	return remaining, nil
+}
+
+type stringDecoder struct{}
+
+func (decoder *stringDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	str, remaining, err := readRawString(data)
+	if err != nil {
+		return nil, err
+	}
+	*(*string)(ptr) = str
+	return remaining, nil
+}
+
+type bytesDecoder struct{}
+
+func (decoder *bytesDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	str, remaining, err := readRawString(data)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	*(*[]byte)(ptr) = decoded
+	return remaining, nil
+}
+
+type pointerDecoder struct {
+	elemType    reflect.Type
+	elemDecoder Decoder
+}
+
+func (decoder *pointerDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	return readNullOr(data, func(data []byte) ([]byte, error) {
+		elemPtr := *(*unsafe.Pointer)(ptr)
+		if elemPtr == nil {
+			sampleObj := reflect.New(decoder.elemType).Interface()
+			elemPtr = (*emptyInterface)(unsafe.Pointer(&sampleObj)).word
+			*(*unsafe.Pointer)(ptr) = elemPtr
+		}
+		return decoder.elemDecoder.Decode(data, elemPtr)
+	})
+}
+
+type jsonUnmarshalerDecoder struct {
+	valType reflect.Type
+}
+
+func (decoder *jsonUnmarshalerDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	obj := reflect.NewAt(decoder.valType, ptr).Interface().(json.Unmarshaler)
+	prefix, remaining, err := readRawValue(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := obj.UnmarshalJSON(prefix); err != nil {
+		return nil, err
+	}
+	return remaining, nil
+}
+
+// readRawValue returns the raw bytes of the next complete JSON value,
+// without interpreting them, so they can be handed off to foreign decoders
+// such as json.Unmarshaler or jsonfmt.Any.
+func readRawValue(data []byte) ([]byte, []byte, error) {
+	data = skipWhitespace(data)
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("jsonfmt: unexpected end of input")
+	}
+	switch data[0] {
+	case '"':
+		_, remaining, err := readRawString(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return data[:len(data)-len(remaining)], remaining, nil
+	case '{', '[':
+		depth := 0
+		inString := false
+		escaped := false
+		for i, c := range data {
+			if inString {
+				switch {
+				case escaped:
+					escaped = false
+				case c == '\\':
+					escaped = true
+				case c == '"':
+					inString = false
+				}
+				continue
+			}
+			switch c {
+			case '"':
+				inString = true
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return data[:i+1], data[i+1:], nil
+				}
+			}
+		}
+		return nil, nil, fmt.Errorf("jsonfmt: unterminated %q", data[0])
+	default:
+		return readRawToken(data)
+	}
+}
+
+type sliceDecoder struct {
+	elemType    reflect.Type
+	elemDecoder Decoder
+	elemSize    uintptr
+}
+
+// sliceHeader mirrors the runtime layout of a slice, so decoded elements can
+// be written straight into the backing array by pointer arithmetic instead
+// of going through reflect.Append on every element.
+type sliceHeader struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+func (decoder *sliceDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	return readNullOr(data, func(data []byte) ([]byte, error) {
+		data = skipWhitespace(data)
+		if len(data) == 0 || data[0] != '[' {
+			return nil, fmt.Errorf("jsonfmt: expected [, got %q", data)
+		}
+		data = skipWhitespace(data[1:])
+		header := (*sliceHeader)(ptr)
+		*header = sliceHeader{}
+		if len(data) > 0 && data[0] == ']' {
+			return data[1:], nil
+		}
+		for {
+			if header.len == header.cap {
+				decoder.grow(header)
+			}
+			elemPtr := unsafe.Pointer(uintptr(header.data) + uintptr(header.len)*decoder.elemSize)
+			var err error
+			data, err = decoder.elemDecoder.Decode(data, elemPtr)
+			if err != nil {
+				return nil, err
+			}
+			header.len++
+			data = skipWhitespace(data)
+			if len(data) == 0 {
+				return nil, fmt.Errorf("jsonfmt: unterminated array")
+			}
+			if data[0] == ',' {
+				data = skipWhitespace(data[1:])
+				continue
+			}
+			if data[0] == ']' {
+				return data[1:], nil
+			}
+			return nil, fmt.Errorf("jsonfmt: expected , or ], got %q", data)
+		}
+	})
+}
+
+// grow doubles header's backing array (starting from 4), copying over the
+// elements already decoded.
+func (decoder *sliceDecoder) grow(header *sliceHeader) {
+	newCap := header.cap * 2
+	if newCap == 0 {
+		newCap = 4
+	}
+	newData := unsafe.Pointer(reflect.New(reflect.ArrayOf(newCap, decoder.elemType)).Pointer())
+	elemType := typesys.Of(decoder.elemType)
+	for i := 0; i < header.len; i++ {
+		src := unsafe.Pointer(uintptr(header.data) + uintptr(i)*decoder.elemSize)
+		dst := unsafe.Pointer(uintptr(newData) + uintptr(i)*decoder.elemSize)
+		elemType.UnsafeSet(dst, src)
+	}
+	header.data = newData
+	header.cap = newCap
+}
+
+type arrayDecoder struct {
+	elemDecoder Decoder
+	elemSize    uintptr
+	length      int
+}
+
+func (decoder *arrayDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	data = skipWhitespace(data)
+	if len(data) == 0 || data[0] != '[' {
+		return nil, fmt.Errorf("jsonfmt: expected [, got %q", data)
+	}
+	data = skipWhitespace(data[1:])
+	for i := 0; i < decoder.length; i++ {
+		elemPtr := unsafe.Pointer(uintptr(ptr) + uintptr(i)*decoder.elemSize)
+		var err error
+		data, err = decoder.elemDecoder.Decode(data, elemPtr)
+		if err != nil {
+			return nil, err
+		}
+		data = skipWhitespace(data)
+		if len(data) == 0 {
+			return nil, fmt.Errorf("jsonfmt: unterminated array")
+		}
+		if i != decoder.length-1 {
+			if data[0] != ',' {
+				return nil, fmt.Errorf("jsonfmt: expected ,, got %q", data)
+			}
+			data = skipWhitespace(data[1:])
+		}
+	}
+	data = skipWhitespace(data)
+	if len(data) == 0 || data[0] != ']' {
+		return nil, fmt.Errorf("jsonfmt: expected ], got %q", data)
+	}
+	return data[1:], nil
+}
+
+type structDecoderField struct {
+	offset  uintptr
+	decoder Decoder
+}
+
+type structDecoder struct {
+	fields map[string]structDecoderField
+}
+
+func (decoder *structDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	return readNullOr(data, func(data []byte) ([]byte, error) {
+		data = skipWhitespace(data)
+		if len(data) == 0 || data[0] != '{' {
+			return nil, fmt.Errorf("jsonfmt: expected {, got %q", data)
+		}
+		data = skipWhitespace(data[1:])
+		if len(data) > 0 && data[0] == '}' {
+			return data[1:], nil
+		}
+		for {
+			var key string
+			var err error
+			key, data, err = readRawString(data)
+			if err != nil {
+				return nil, err
+			}
+			data = skipWhitespace(data)
+			if len(data) == 0 || data[0] != ':' {
+				return nil, fmt.Errorf("jsonfmt: expected :, got %q", data)
+			}
+			data = skipWhitespace(data[1:])
+			field, found := decoder.fields[key]
+			if !found {
+				_, data, err = readRawValue(data)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				data, err = field.decoder.Decode(data, unsafe.Pointer(uintptr(ptr)+field.offset))
+				if err != nil {
+					return nil, err
+				}
+			}
+			data = skipWhitespace(data)
+			if len(data) == 0 {
+				return nil, fmt.Errorf("jsonfmt: unterminated object")
+			}
+			if data[0] == ',' {
+				data = skipWhitespace(data[1:])
+				continue
+			}
+			if data[0] == '}' {
+				return data[1:], nil
+			}
+			return nil, fmt.Errorf("jsonfmt: expected , or }, got %q", data)
+		}
+	})
+}
+
+func decoderOfStruct(prefix string, valType reflect.Type) *structDecoder {
+	fields := map[string]structDecoderField{}
+	for i := 0; i < valType.NumField(); i++ {
+		field := valType.Field(i)
+		tag := field.Tag.Get(defaultConfig.tagKey())
+		name, _, _ := defaultConfig.parseTag(field, tag)
+		if name == "" {
+			continue
+		}
+		fields[name] = structDecoderField{
+			offset:  field.Offset,
+			decoder: decoderOf(prefix+" ."+name, field.Type),
+		}
+	}
+	return &structDecoder{fields: fields}
+}
+
+type mapDecoder struct {
+	mapType     reflect.Type
+	keyDecoder  mapKeyDecoder
+	elemType    reflect.Type
+	elemDecoder Decoder
+}
+
+type mapKeyDecoder interface {
+	DecodeMapKey(data []byte, keyPtr unsafe.Pointer) ([]byte, error)
+}
+
+type mapStringKeyDecoder struct{}
+
+func (decoder *mapStringKeyDecoder) DecodeMapKey(data []byte, keyPtr unsafe.Pointer) ([]byte, error) {
+	str, remaining, err := readRawString(data)
+	if err != nil {
+		return nil, err
+	}
+	*(*string)(keyPtr) = str
+	return remaining, nil
+}
+
+type mapNumberKeyDecoder struct {
+	keyType reflect.Type
+}
+
+func (decoder *mapNumberKeyDecoder) DecodeMapKey(data []byte, keyPtr unsafe.Pointer) ([]byte, error) {
+	str, remaining, err := readRawString(data)
+	if err != nil {
+		return nil, err
+	}
+	switch decoder.keyType.Kind() {
+	case reflect.Float32:
+		val, err := strconv.ParseFloat(str, 32)
+		if err != nil {
+			return nil, err
+		}
+		*(*float32)(keyPtr) = float32(val)
+	case reflect.Float64:
+		val, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, err
+		}
+		*(*float64)(keyPtr) = val
+	case reflect.Uint8:
+		val, err := strconv.ParseUint(str, 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		*(*uint8)(keyPtr) = uint8(val)
+	case reflect.Uint16:
+		val, err := strconv.ParseUint(str, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		*(*uint16)(keyPtr) = uint16(val)
+	case reflect.Uint32:
+		val, err := strconv.ParseUint(str, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		*(*uint32)(keyPtr) = uint32(val)
+	case reflect.Uint, reflect.Uint64:
+		val, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		*(*uint64)(keyPtr) = val
+	case reflect.Int8:
+		val, err := strconv.ParseInt(str, 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		*(*int8)(keyPtr) = int8(val)
+	case reflect.Int16:
+		val, err := strconv.ParseInt(str, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		*(*int16)(keyPtr) = int16(val)
+	case reflect.Int32:
+		val, err := strconv.ParseInt(str, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		*(*int32)(keyPtr) = int32(val)
+	default:
+		val, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		*(*int64)(keyPtr) = val
+	}
+	return remaining, nil
+}
+
+type mapInterfaceKeyDecoder struct{}
+
+func (decoder *mapInterfaceKeyDecoder) DecodeMapKey(data []byte, keyPtr unsafe.Pointer) ([]byte, error) {
+	str, remaining, err := readRawString(data)
+	if err != nil {
+		return nil, err
+	}
+	*(*interface{})(keyPtr) = str
+	return remaining, nil
+}
+
+func (decoder *mapDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	return readNullOr(data, func(data []byte) ([]byte, error) {
+		data = skipWhitespace(data)
+		if len(data) == 0 || data[0] != '{' {
+			return nil, fmt.Errorf("jsonfmt: expected {, got %q", data)
+		}
+		mapType := typesys.Of(decoder.mapType)
+		keyType := typesys.Of(decoder.mapType.Key())
+		elemType := typesys.Of(decoder.elemType)
+		mapPtr := mapType.UnsafeMakeMap(4)
+		data = skipWhitespace(data[1:])
+		if len(data) > 0 && data[0] == '}' {
+			mapType.UnsafeSet(ptr, mapPtr)
+			return data[1:], nil
+		}
+		for {
+			keyPtr := keyType.UnsafeNew()
+			var err error
+			data, err = decoder.keyDecoder.DecodeMapKey(data, keyPtr)
+			if err != nil {
+				return nil, err
+			}
+			data = skipWhitespace(data)
+			if len(data) == 0 || data[0] != ':' {
+				return nil, fmt.Errorf("jsonfmt: expected :, got %q", data)
+			}
+			data = skipWhitespace(data[1:])
+			elemPtr := elemType.UnsafeNew()
+			data, err = decoder.elemDecoder.Decode(data, elemPtr)
+			if err != nil {
+				return nil, err
+			}
+			mapType.UnsafeSetMapIndex(mapPtr, keyPtr, elemPtr)
+			data = skipWhitespace(data)
+			if len(data) == 0 {
+				return nil, fmt.Errorf("jsonfmt: unterminated object")
+			}
+			if data[0] == ',' {
+				data = skipWhitespace(data[1:])
+				continue
+			}
+			if data[0] == '}' {
+				mapType.UnsafeSet(ptr, mapPtr)
+				return data[1:], nil
+			}
+			return nil, fmt.Errorf("jsonfmt: expected , or }, got %q", data)
+		}
+	})
+}
+
+func decoderOfMap(prefix string, valType reflect.Type) *mapDecoder {
+	keyType := valType.Key()
+	var keyDecoder mapKeyDecoder
+	switch {
+	case keyType.Kind() == reflect.String:
+		keyDecoder = &mapStringKeyDecoder{}
+	case keyType.Kind() == reflect.Interface:
+		keyDecoder = &mapInterfaceKeyDecoder{}
+	default:
+		keyDecoder = &mapNumberKeyDecoder{keyType: keyType}
+	}
+	return &mapDecoder{
+		mapType:     valType,
+		keyDecoder:  keyDecoder,
+		elemType:    valType.Elem(),
+		elemDecoder: decoderOf(prefix+" [mapElem]", valType.Elem()),
+	}
+}
+
+type emptyInterfaceDecoder struct{}
+
+func (decoder *emptyInterfaceDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	data = skipWhitespace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("jsonfmt: unexpected end of input")
+	}
+	var val interface{}
+	var remaining []byte
+	var err error
+	switch data[0] {
+	case '"':
+		var str string
+		str, remaining, err = readRawString(data)
+		val = str
+	case '{':
+		m := map[string]interface{}{}
+		remaining, err = (&mapDecoder{
+			mapType:     reflect.TypeOf(m),
+			keyDecoder:  &mapStringKeyDecoder{},
+			elemType:    reflect.TypeOf((*interface{})(nil)).Elem(),
+			elemDecoder: &emptyInterfaceDecoder{},
+		}).Decode(data, unsafe.Pointer(&m))
+		val = m
+	case '[':
+		var s []interface{}
+		emptyInterfaceType := reflect.TypeOf((*interface{})(nil)).Elem()
+		remaining, err = (&sliceDecoder{
+			elemType:    emptyInterfaceType,
+			elemDecoder: &emptyInterfaceDecoder{},
+			elemSize:    emptyInterfaceType.Size(),
+		}).Decode(data, unsafe.Pointer(&s))
+		val = s
+	case 't', 'f':
+		var b bool
+		remaining, err = (&boolDecoder{}).Decode(data, unsafe.Pointer(&b))
+		val = b
+	case 'n':
+		if len(data) < 4 || string(data[:4]) != "null" {
+			return nil, fmt.Errorf("jsonfmt: invalid literal %q", data)
+		}
+		remaining = data[4:]
+		val = nil
+	default:
+		var f float64
+		remaining, err = (&float64Decoder{}).Decode(data, unsafe.Pointer(&f))
+		val = f
+	}
+	if err != nil {
+		return nil, err
+	}
+	*(*interface{})(ptr) = val
+	return remaining, nil
+}
+
+type nonEmptyInterfaceDecoder struct{}
+
+func (decoder *nonEmptyInterfaceDecoder) Decode(data []byte, ptr unsafe.Pointer) ([]byte, error) {
+	return nil, fmt.Errorf("jsonfmt: can not decode into non-empty interface")
+}
@@ -0,0 +1,58 @@
+package jsonfmt
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/json-iterator/go/require"
+)
+
+func Test_DecoderOf_slice_grows_past_initial_capacity(t *testing.T) {
+	should := require.New(t)
+	var ints []int
+	_, err := DecoderOf(reflect.TypeOf(ints)).Decode([]byte(`[1,2,3,4,5,6,7,8,9]`), unsafe.Pointer(&ints))
+	should.NoError(err)
+	should.Equal([]int{1, 2, 3, 4, 5, 6, 7, 8, 9}, ints)
+}
+
+func Test_decoderOfStruct_uses_json_tag_name(t *testing.T) {
+	should := require.New(t)
+	type sample struct {
+		Name    string `json:"name"`
+		Age     int    `json:"age"`
+		private string
+	}
+	var v sample
+	_, err := DecoderOf(reflect.TypeOf(v)).Decode([]byte(`{"name":"a","age":2}`), unsafe.Pointer(&v))
+	should.NoError(err)
+	should.Equal(sample{Name: "a", Age: 2}, v)
+}
+
+func Test_DecoderOf_map_string_key(t *testing.T) {
+	should := require.New(t)
+	m := map[string]int{}
+	_, err := DecoderOf(reflect.TypeOf(m)).Decode([]byte(`{"a":1,"b":2}`), unsafe.Pointer(&m))
+	should.NoError(err)
+	should.Equal(map[string]int{"a": 1, "b": 2}, m)
+}
+
+// Test_DecoderOf_direct_pointer_nil_errors documents the asymmetry with
+// EncoderOf's onePtrInterfaceEncoder: a nil *int has nowhere to write a
+// freshly allocated pointer back to when reached through PtrOf, so
+// DecoderOf reports an explicit error instead of silently doing nothing.
+func Test_DecoderOf_direct_pointer_nil_errors(t *testing.T) {
+	should := require.New(t)
+	var p *int
+	_, err := DecoderOf(reflect.TypeOf(p)).Decode([]byte(`1`), PtrOf(p))
+	should.Error(err)
+}
+
+func Test_DecoderOf_direct_pointer_non_nil_decodes_in_place(t *testing.T) {
+	should := require.New(t)
+	n := 0
+	p := &n
+	_, err := DecoderOf(reflect.TypeOf(p)).Decode([]byte(`7`), PtrOf(p))
+	should.NoError(err)
+	should.Equal(7, n)
+}
@@ -3,11 +3,11 @@ package jsonfmt
 import (
 	"unsafe"
 	"reflect"
-	"strings"
-	"unicode"
 	"sync"
 	"fmt"
 	"encoding/json"
+
+	"github.com/v2pro/plz/msgfmt/jsonfmt/internal/typesys"
 )
 
 var bytesType = reflect.TypeOf([]byte(nil))
@@ -26,7 +26,7 @@ func EncoderOf(valType reflect.Type) Encoder {
 		return encoderObj.(Encoder)
 	}
 	encoder := encoderOf("", valType)
-	if isOnePtr(valType) {
+	if typesys.Of(valType).IsDirect() {
 		encoder = &onePtrInterfaceEncoder{encoder}
 	}
 	encoderCache.Store(valType, encoder)
@@ -34,20 +34,8 @@ func EncoderOf(valType reflect.Type) Encoder {
 }
 
 func encoderOf(prefix string, valType reflect.Type) Encoder {
-	if bytesType == valType {
-		return &bytesEncoder{}
-	}
-	if valType.Implements(errorType) && valType.Kind() == reflect.Ptr {
-		sampleObj := reflect.New(valType).Elem().Interface()
-		return &pointerEncoder{elemEncoder: &errorEncoder{
-			sampleInterface: *(*emptyInterface)(unsafe.Pointer(&sampleObj)),
-		}}
-	}
-	if valType.Implements(jsonMarshalerType) && valType.Kind() != reflect.Ptr {
-		sampleObj := reflect.New(valType).Elem().Interface()
-		return &jsonMarshalerEncoder{
-			sampleInterface: *(*emptyInterface)(unsafe.Pointer(&sampleObj)),
-		}
+	if encoder := specialCaseEncoder(valType); encoder != nil {
+		return encoder
 	}
 	switch valType.Kind() {
 	case reflect.Int8:
@@ -89,7 +77,7 @@ func encoderOf(prefix string, valType reflect.Type) Encoder {
 			length:      valType.Len(),
 		}
 	case reflect.Struct:
-		return encoderOfStruct(prefix, valType)
+		return defaultConfig.encoderOfStruct(prefix, valType)
 	case reflect.Map:
 		return encoderOfMap(prefix, valType)
 	case reflect.Interface:
@@ -101,6 +89,29 @@ func encoderOf(prefix string, valType reflect.Type) Encoder {
 	return &unsupportedEncoder{fmt.Sprintf(`"can not encode %s %s to json"`, valType.String(), prefix)}
 }
 
+// specialCaseEncoder returns the encoder for valType if it matches one of
+// the types that need special handling ahead of the Kind() switch ([]byte,
+// error implementations, json.Marshaler implementations), or nil if valType
+// is not special-cased. Both the package-level encoderOf and Config.encoderOf
+// call this before dispatching on Kind(), so a Config never loses these
+// cases for a struct field or slice element that happens to be one of them.
+func specialCaseEncoder(valType reflect.Type) Encoder {
+	if bytesType == valType {
+		return &bytesEncoder{}
+	}
+	if valType.Implements(errorType) && valType.Kind() == reflect.Ptr {
+		return &pointerEncoder{elemEncoder: &errorEncoder{
+			sampleInterface: emptyInterface{typ: typesys.Of(valType).RType()},
+		}}
+	}
+	if valType.Implements(jsonMarshalerType) && valType.Kind() != reflect.Ptr {
+		return &jsonMarshalerEncoder{
+			sampleInterface: emptyInterface{typ: typesys.Of(valType).RType()},
+		}
+	}
+	return nil
+}
+
 type unsupportedEncoder struct {
 	msg string
 }
@@ -111,15 +122,14 @@ func (encoder *unsupportedEncoder) Encode(space []byte, ptr unsafe.Pointer) []by
 
 func encoderOfMap(prefix string, valType reflect.Type) *mapEncoder {
 	keyEncoder := encoderOfMapKey(prefix, valType.Key())
-	sampleObj := reflect.MakeMap(valType).Interface()
 	elemType := valType.Elem()
 	elemEncoder := encoderOf(prefix+" [mapElem]", elemType)
-	if isOnePtr(elemType) {
+	if typesys.Of(elemType).IsDirect() {
 		elemEncoder = &onePtrInterfaceEncoder{elemEncoder}
 	}
 	return &mapEncoder{
 		keyEncoder:      keyEncoder,
-		sampleInterface: *(*emptyInterface)(unsafe.Pointer(&sampleObj)),
+		sampleInterface: emptyInterface{typ: typesys.Of(valType).RType()},
 	}
 }
 
@@ -146,67 +156,6 @@ func _encoderOfMapKey(prefix string, keyType reflect.Type) Encoder {
 	return &mapNumberKeyEncoder{keyEncoder}
 }
 
-func isOnePtr(valType reflect.Type) bool {
-	if valType.Kind() == reflect.Ptr {
-		return true
-	}
-	if valType.Kind() == reflect.Struct &&
-		valType.NumField() == 1 &&
-		valType.Field(0).Type.Kind() == reflect.Ptr {
-		return true
-	}
-	if valType.Kind() == reflect.Array &&
-		valType.Len() == 1 &&
-		valType.Elem().Kind() == reflect.Ptr {
-		return true
-	}
-	return false
-}
-
-func encoderOfStruct(prefix string, valType reflect.Type) *structEncoder {
-	var fields []structEncoderField
-	for i := 0; i < valType.NumField(); i++ {
-		field := valType.Field(i)
-		name := getFieldName(field)
-		if name == "" {
-			continue
-		}
-		prefix := ""
-		if len(fields) != 0 {
-			prefix += ","
-		}
-		prefix += `"`
-		prefix += name
-		prefix += `":`
-		fields = append(fields, structEncoderField{
-			offset:  field.Offset,
-			prefix:  prefix,
-			encoder: encoderOf(prefix+" ."+name, field.Type),
-		})
-	}
-	return &structEncoder{
-		fields: fields,
-	}
-}
-
-func getFieldName(field reflect.StructField) string {
-	if !unicode.IsUpper(rune(field.Name[0])) {
-		return ""
-	}
-	jsonTag := field.Tag.Get("json")
-	if jsonTag == "" {
-		return field.Name
-	}
-	parts := strings.Split(jsonTag, ",")
-	if parts[0] == "-" {
-		return ""
-	}
-	if parts[0] == "" {
-		return field.Name
-	}
-	return parts[0]
-}
-
 func PtrOf(val interface{}) unsafe.Pointer {
 	return (*emptyInterface)(unsafe.Pointer(&val)).word
 }
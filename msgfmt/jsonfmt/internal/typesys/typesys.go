@@ -0,0 +1,138 @@
+// Package typesys caches per-reflect.Type metadata and unsafe accessors,
+// so callers in jsonfmt no longer need to build sample reflect.Values and
+// chase *emptyInterface casts inline at every encoderOf call site.
+package typesys
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+type eface struct {
+	rtype unsafe.Pointer
+	word  unsafe.Pointer
+}
+
+// Type is a cached handle on a reflect.Type exposing the unsafe
+// primitives encoders and decoders need.
+type Type struct {
+	reflectType reflect.Type
+	rtype       unsafe.Pointer
+	direct      bool
+}
+
+var cache = &sync.Map{}
+
+// Of returns the cached Type handle for reflectType, building one on
+// first use.
+func Of(reflectType reflect.Type) *Type {
+	if cached, found := cache.Load(reflectType); found {
+		return cached.(*Type)
+	}
+	sampleObj := reflect.New(reflectType).Elem().Interface()
+	t := &Type{
+		reflectType: reflectType,
+		rtype:       (*eface)(unsafe.Pointer(&sampleObj)).rtype,
+		direct:      isDirect(reflectType),
+	}
+	actual, _ := cache.LoadOrStore(reflectType, t)
+	return actual.(*Type)
+}
+
+// isDirect reports whether reflectType is a pointer, a struct with a single
+// pointer field, or a one-element array of a pointer; values of these types
+// are stored directly in the word of an interface{}. Maps, channels and
+// funcs, which Go also stores directly, report false.
+func isDirect(reflectType reflect.Type) bool {
+	switch reflectType.Kind() {
+	case reflect.Ptr:
+		return true
+	case reflect.Struct:
+		return reflectType.NumField() == 1 && reflectType.Field(0).Type.Kind() == reflect.Ptr
+	case reflect.Array:
+		return reflectType.Len() == 1 && reflectType.Elem().Kind() == reflect.Ptr
+	}
+	return false
+}
+
+// RType returns the runtime type pointer backing this type, for building
+// interface{} headers by hand.
+func (t *Type) RType() unsafe.Pointer {
+	return t.rtype
+}
+
+// IsDirect reports whether this type is a pointer, or a single-field
+// struct or one-element array of a pointer, all of which are stored
+// directly (one pointer word) inside an interface{}. Maps, channels and
+// funcs report false.
+func (t *Type) IsDirect() bool {
+	return t.direct
+}
+
+// UnsafeNew allocates a new zero value of this type and returns a pointer
+// to it.
+func (t *Type) UnsafeNew() unsafe.Pointer {
+	return unsafe.Pointer(reflect.New(t.reflectType).Pointer())
+}
+
+// UnsafeIndirect dereferences ptr, which must point to a value of this
+// type, returning the pointer it holds if this type is a pointer kind, or
+// ptr itself otherwise.
+func (t *Type) UnsafeIndirect(ptr unsafe.Pointer) unsafe.Pointer {
+	if t.reflectType.Kind() != reflect.Ptr {
+		return ptr
+	}
+	return *(*unsafe.Pointer)(ptr)
+}
+
+// UnsafeSet copies the value at src into dst.
+func (t *Type) UnsafeSet(dst, src unsafe.Pointer) {
+	reflect.NewAt(t.reflectType, dst).Elem().Set(reflect.NewAt(t.reflectType, src).Elem())
+}
+
+// PackEFace builds an interface{} around the value at ptr without going
+// through reflect.Value.Interface(), collapsing the direct/indirect
+// special-casing encoders used to do ad hoc at every call site.
+func (t *Type) PackEFace(ptr unsafe.Pointer) interface{} {
+	var packed interface{}
+	header := (*eface)(unsafe.Pointer(&packed))
+	header.rtype = t.rtype
+	if t.direct {
+		header.word = *(*unsafe.Pointer)(ptr)
+	} else {
+		header.word = ptr
+	}
+	return packed
+}
+
+// UnsafeMakeMap creates a new map of this type with the given size hint
+// and returns a pointer to it.
+func (t *Type) UnsafeMakeMap(sizeHint int) unsafe.Pointer {
+	newMap := reflect.New(t.reflectType)
+	newMap.Elem().Set(reflect.MakeMapWithSize(t.reflectType, sizeHint))
+	return unsafe.Pointer(newMap.Pointer())
+}
+
+// UnsafeSetMapIndex assigns the value at valPtr to the key at keyPtr in
+// the map at mapPtr.
+func (t *Type) UnsafeSetMapIndex(mapPtr, keyPtr, valPtr unsafe.Pointer) {
+	mapVal := reflect.NewAt(t.reflectType, mapPtr).Elem()
+	keyVal := reflect.NewAt(t.reflectType.Key(), keyPtr).Elem()
+	elemVal := reflect.NewAt(t.reflectType.Elem(), valPtr).Elem()
+	mapVal.SetMapIndex(keyVal, elemVal)
+}
+
+// UnsafeIterate walks every key/value pair in the map at mapPtr, invoking
+// visit with a pointer to a copy of each.
+func (t *Type) UnsafeIterate(mapPtr unsafe.Pointer, visit func(keyPtr, valPtr unsafe.Pointer)) {
+	mapVal := reflect.NewAt(t.reflectType, mapPtr).Elem()
+	for _, keyVal := range mapVal.MapKeys() {
+		elemVal := mapVal.MapIndex(keyVal)
+		keyCopy := reflect.New(keyVal.Type())
+		keyCopy.Elem().Set(keyVal)
+		elemCopy := reflect.New(elemVal.Type())
+		elemCopy.Elem().Set(elemVal)
+		visit(unsafe.Pointer(keyCopy.Pointer()), unsafe.Pointer(elemCopy.Pointer()))
+	}
+}
@@ -0,0 +1,26 @@
+package typesys
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/json-iterator/go/require"
+)
+
+func Test_Type_UnsafeIterate(t *testing.T) {
+	should := require.New(t)
+	m := map[string]int{"a": 1, "b": 2}
+	seen := map[string]int{}
+	Of(reflect.TypeOf(m)).UnsafeIterate(unsafe.Pointer(&m), func(keyPtr, valPtr unsafe.Pointer) {
+		seen[*(*string)(keyPtr)] = *(*int)(valPtr)
+	})
+	should.Equal(m, seen)
+}
+
+func Test_Type_PackEFace(t *testing.T) {
+	should := require.New(t)
+	v := 42
+	packed := Of(reflect.TypeOf(v)).PackEFace(unsafe.Pointer(&v))
+	should.Equal(42, packed.(int))
+}
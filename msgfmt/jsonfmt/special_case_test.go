@@ -0,0 +1,29 @@
+package jsonfmt
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/json-iterator/go/require"
+)
+
+type marshalerStub struct{}
+
+func (marshalerStub) MarshalJSON() ([]byte, error) {
+	return []byte(`"stub"`), nil
+}
+
+// Test_Config_encoderOf_specialCases guards against Config.encoderOf
+// regressing to a plain Kind() switch: []byte, error implementers and
+// json.Marshaler implementers must dispatch the same way whether they are
+// reached through the package-level EncoderOf or through a Config.
+func Test_Config_encoderOf_specialCases(t *testing.T) {
+	should := require.New(t)
+	cfg := Config{Naming: SnakeCase}
+
+	bytesEnc := cfg.encoderOf("", bytesType)
+	should.Equal(reflect.TypeOf(&bytesEncoder{}), reflect.TypeOf(bytesEnc))
+
+	marshalerEnc := cfg.encoderOf("", reflect.TypeOf(marshalerStub{}))
+	should.Equal(reflect.TypeOf(&jsonMarshalerEncoder{}), reflect.TypeOf(marshalerEnc))
+}
@@ -0,0 +1,95 @@
+package jsonfmt
+
+import (
+	"io"
+	"unsafe"
+)
+
+// Stream wraps an io.Writer with an internal buffer so encoders can push
+// JSON out in chunks instead of materializing the whole payload in memory
+// before handing it to the writer.
+type Stream struct {
+	out   io.Writer
+	buf   []byte
+	Error error
+}
+
+// NewStream creates a Stream backed by out, with an internal buffer
+// pre-sized to bufSize bytes.
+func NewStream(out io.Writer, bufSize int) *Stream {
+	return &Stream{out: out, buf: make([]byte, 0, bufSize)}
+}
+
+// WriteRaw appends s to the buffer verbatim, with no quoting.
+func (stream *Stream) WriteRaw(s string) {
+	stream.buf = append(stream.buf, s...)
+}
+
+// WriteString appends s to the buffer as a quoted JSON string, escaping
+// quotes, backslashes and control characters so s round-trips through a
+// JSON parser unchanged.
+func (stream *Stream) WriteString(s string) {
+	stream.buf = append(stream.buf, '"')
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' {
+			continue
+		}
+		stream.buf = append(stream.buf, s[start:i]...)
+		switch c {
+		case '"':
+			stream.buf = append(stream.buf, '\\', '"')
+		case '\\':
+			stream.buf = append(stream.buf, '\\', '\\')
+		case '\n':
+			stream.buf = append(stream.buf, '\\', 'n')
+		case '\r':
+			stream.buf = append(stream.buf, '\\', 'r')
+		case '\t':
+			stream.buf = append(stream.buf, '\\', 't')
+		default:
+			stream.buf = append(stream.buf, `\u00`...)
+			const hex = "0123456789abcdef"
+			stream.buf = append(stream.buf, hex[c>>4], hex[c&0xf])
+		}
+		start = i + 1
+	}
+	stream.buf = append(stream.buf, s[start:]...)
+	stream.buf = append(stream.buf, '"')
+}
+
+// Flush writes any buffered bytes to the underlying io.Writer.
+func (stream *Stream) Flush() error {
+	if stream.Error != nil {
+		return stream.Error
+	}
+	if len(stream.buf) == 0 {
+		return nil
+	}
+	_, err := stream.out.Write(stream.buf)
+	stream.buf = stream.buf[:0]
+	if err != nil {
+		stream.Error = err
+	}
+	return err
+}
+
+// StreamEncoder is implemented by encoders that can push their output
+// straight into a Stream instead of growing a single []byte. The struct
+// encoder implements this to flush after each field rather than
+// accumulating the whole payload.
+type StreamEncoder interface {
+	EncodeStream(stream *Stream, ptr unsafe.Pointer)
+}
+
+// WriteVal encodes the value at ptr into stream, using encoder's
+// EncodeStream when it implements StreamEncoder and falling back to
+// Encode otherwise.
+func (stream *Stream) WriteVal(encoder Encoder, ptr unsafe.Pointer) {
+	if streamEncoder, ok := encoder.(StreamEncoder); ok {
+		streamEncoder.EncodeStream(stream, ptr)
+		return
+	}
+	stream.buf = encoder.Encode(stream.buf, ptr)
+}
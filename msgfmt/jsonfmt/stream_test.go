@@ -0,0 +1,62 @@
+package jsonfmt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/json-iterator/go/require"
+)
+
+func Test_Stream_WriteString_escapes(t *testing.T) {
+	should := require.New(t)
+	var buf bytes.Buffer
+	stream := NewStream(&buf, 64)
+	stream.WriteString("a\"b\\c\nd")
+	should.NoError(stream.Flush())
+	should.Equal(`"a\"b\\c\nd"`, buf.String())
+}
+
+type streamEncoderStub struct {
+	called bool
+}
+
+func (encoder *streamEncoderStub) Encode(space []byte, ptr unsafe.Pointer) []byte {
+	return append(space, "fallback"...)
+}
+
+func (encoder *streamEncoderStub) EncodeStream(stream *Stream, ptr unsafe.Pointer) {
+	encoder.called = true
+	stream.WriteRaw("fast-path")
+}
+
+func Test_Stream_WriteVal_prefers_EncodeStream(t *testing.T) {
+	should := require.New(t)
+	var buf bytes.Buffer
+	stream := NewStream(&buf, 64)
+	encoder := &streamEncoderStub{}
+	stream.WriteVal(encoder, nil)
+	should.True(encoder.called)
+	should.NoError(stream.Flush())
+	should.Equal("fast-path", buf.String())
+}
+
+func Test_structEncoder_EncodeStream_matches_Encode(t *testing.T) {
+	should := require.New(t)
+	type sample struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	cfg := Config{}
+	structEnc := cfg.encoderOfStruct("", reflect.TypeOf(sample{}))
+	v := sample{Name: "a", Age: 1}
+
+	var buf bytes.Buffer
+	stream := NewStream(&buf, 64)
+	structEnc.EncodeStream(stream, unsafe.Pointer(&v))
+	should.NoError(stream.Flush())
+
+	spaceOut := structEnc.Encode(nil, unsafe.Pointer(&v))
+	should.Equal(string(spaceOut), buf.String())
+}